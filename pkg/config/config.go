@@ -0,0 +1,319 @@
+// Package config handles parsing of the sriov-cni netconf and resolution of
+// the VF that a given invocation should operate on.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+const (
+	defaultCNIDir = "/var/lib/cni/sriov"
+
+	// defaultOvsDpdkBridge is used when a netconf requests ovs-dpdk mode
+	// without naming a bridge explicitly.
+	defaultOvsDpdkBridge = "br-dpdk"
+
+	// globalConfigPath, if present, sets cluster-wide defaults that apply
+	// to every invocation unless a netconf overrides them explicitly.
+	globalConfigPath = "/etc/sriov-cni/config.json"
+)
+
+// resourcePoolConfigPath maps PF netdev names to the resource pool name
+// they were handed out under, as written by the sriov device plugin. It is
+// only consulted when forceResourceName is enabled. A var, not a const, so
+// tests can point it at a fixture.
+var resourcePoolConfigPath = "/etc/pcidp/resourcePools.json"
+
+// globalConfig holds cluster-wide defaults loaded once at startup from
+// globalConfigPath.
+type globalConfig struct {
+	ForceResourceName bool `json:"forceResourceName"`
+}
+
+var defaultForceResourceName bool
+
+func init() {
+	data, err := ioutil.ReadFile(globalConfigPath)
+	if err != nil {
+		return
+	}
+
+	var gc globalConfig
+	if err := json.Unmarshal(data, &gc); err != nil {
+		return
+	}
+	defaultForceResourceName = gc.ForceResourceName
+}
+
+// DPDKConf carries the identifiers the legacy sysfs-driver-unbind DPDK
+// datapath needs once a VF has been resolved.
+type DPDKConf struct {
+	PCIaddr string `json:"pci_addr"`
+	Ifname  string `json:"ifname"`
+	VFID    int    `json:"vfid"`
+}
+
+// OvsDpdkConf configures the ovs-dpdk vSwitch backend. It is only consulted
+// when NetConf.Mode is "ovs-dpdk".
+type OvsDpdkConf struct {
+	// Bridge is the pre-existing OVS bridge the VF's dpdk port is attached
+	// to. Defaults to "br-dpdk".
+	Bridge string `json:"bridge"`
+	// VhostUserSocketDir, together with VhostUser, selects the
+	// dpdkvhostuserclient port type and sets its vhost-server-path to
+	// <VhostUserSocketDir>/<port>. Left empty, VhostUser instead attaches
+	// a plain dpdkvhostuser port, whose socket lands wherever
+	// ovs-vswitchd's own vhost-sock-dir config points.
+	VhostUserSocketDir string `json:"vhostUserSocketDir"`
+	// VhostUser, when true, attaches the port as dpdkvhostuser/
+	// dpdkvhostuserclient instead of a plain dpdk port, so that a VM or
+	// DPDK app in the pod can consume it directly.
+	VhostUser bool `json:"vhostUser"`
+}
+
+// IPAMConfig shadows types.NetConf.IPAM so sriov-cni can recognise the
+// "grpc" IPAM type, which needs extra keys the upstream types.IPAM struct
+// doesn't carry.
+type IPAMConfig struct {
+	Type string `json:"type,omitempty"`
+	// Socket is the UDS the grpc IPAM daemon listens on, e.g.
+	// "unix:///var/lib/cni/sriov-ipam.sock". Only consulted when
+	// Type == "grpc".
+	Socket string `json:"socket,omitempty"`
+	// Deadline overrides the default 5s per-RPC timeout, e.g. "10s".
+	Deadline string `json:"deadline,omitempty"`
+	// PoolName identifies the address pool the daemon should allocate
+	// from. Only consulted when Type == "grpc".
+	PoolName string `json:"poolName,omitempty"`
+}
+
+// VfInformation identifies the VF resolved for this invocation.
+type VfInformation struct {
+	PCIaddr string `json:"pciaddr"`
+	Pfname  string `json:"pfname"`
+	Vfid    int    `json:"vfid"`
+}
+
+// NetConf extends types.NetConf with the sriov-cni specific configuration.
+type NetConf struct {
+	types.NetConf
+	DPDKMode bool     `json:"-"`
+	DPDKConf DPDKConf `json:"dpdk,omitempty"`
+	Sharedvf bool     `json:"sharedvf,omitempty"`
+	CNIDir   string   `json:"cniDir,omitempty"`
+	IF0NAME  string   `json:"if0name,omitempty"`
+	L2Mode   bool     `json:"l2enable,omitempty"`
+	Vlan     int      `json:"vlan,omitempty"`
+	MAC      string   `json:"mac,omitempty"`
+	Mtu      int      `json:"mtu,omitempty"`
+	Master   string   `json:"master"`
+
+	// IPAM shadows the promoted types.NetConf.IPAM field; see IPAMConfig.
+	IPAM IPAMConfig `json:"ipam,omitempty"`
+
+	// Spoofchk and Trust are "on"/"off"; left empty, the PF's current
+	// setting for the VF is left untouched.
+	Spoofchk string `json:"spoofchk,omitempty"`
+	Trust    string `json:"trust,omitempty"`
+	// MinTxRate/MaxTxRate are in Mbps.
+	MinTxRate *int `json:"min_tx_rate,omitempty"`
+	MaxTxRate *int `json:"max_tx_rate,omitempty"`
+	// LinkState is one of "auto", "enable" or "disable".
+	LinkState string `json:"link_state,omitempty"`
+
+	// ExternallyManaged, when true, makes the plugin a pure consumer of
+	// an already-provisioned VF: it still moves the netdev into the
+	// container netns and runs IPAM, but never writes MAC/VLAN/spoofchk/
+	// trust/rate/link-state on the PF, and never touches numVFs or
+	// driver bindings. Use this when an operator or the host, not this
+	// CNI, owns VF provisioning.
+	ExternallyManaged bool `json:"externallyManaged,omitempty"`
+
+	// Mode selects an alternate datapath for the resolved VF. Currently
+	// recognised values are "" (kernel netdev, the default), "dpdk" and
+	// "ovs-dpdk". "dpdk" fills DPDKConf from the resolved VF and skips
+	// configuring IPAM addresses inside the container; the VF netdev is
+	// still moved into the container netns as in the default mode, and no
+	// driver is unbound or rebound.
+	Mode string `json:"mode,omitempty"`
+
+	// OvsDpdk is only consulted when Mode == "ovs-dpdk".
+	OvsDpdk OvsDpdkConf `json:"ovsDpdk,omitempty"`
+
+	// SwitchdevRaw lets the netconf force switchdev handling on or off. If
+	// left unset, it is auto-detected from the PF's eswitch mode in
+	// LoadConf.
+	SwitchdevRaw *bool `json:"switchdev,omitempty"`
+	// Switchdev is the resolved value of SwitchdevRaw, always populated by
+	// LoadConf.
+	Switchdev bool `json:"-"`
+	// OvsBridge, when set together with Switchdev, makes the plugin add
+	// the VF's representor as a port on this bridge itself (e.g. for OVS
+	// hardware-offload) instead of leaving that to a companion
+	// controller.
+	OvsBridge string `json:"ovsBridge,omitempty"`
+
+	DeviceInfo *VfInformation `json:"deviceInfo,omitempty"`
+
+	// ResourceName is the pool a NetworkAttachmentDefinition declares it
+	// draws VFs from, e.g. "intel.com/sriov_vfio". It is only enforced
+	// when ForceResourceName is true.
+	ResourceName string `json:"resourceName,omitempty"`
+	// ForceResourceNameRaw lets a netconf override the cluster-wide
+	// forceResourceName default from globalConfigPath.
+	ForceResourceNameRaw *bool `json:"forceResourceName,omitempty"`
+	// ForceResourceName is the resolved value of ForceResourceNameRaw,
+	// always populated by LoadConf.
+	ForceResourceName bool `json:"-"`
+}
+
+// LoadConf parses and validates the supplied CNI stdin data into a NetConf.
+func LoadConf(data []byte) (*NetConf, error) {
+	n := &NetConf{}
+	if err := json.Unmarshal(data, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+
+	if n.Master == "" && n.DeviceInfo == nil {
+		return nil, fmt.Errorf(`"master" or "deviceInfo" field is required`)
+	}
+
+	if n.CNIDir == "" {
+		n.CNIDir = defaultCNIDir
+	}
+
+	switch n.Mode {
+	case "":
+	case "dpdk":
+		n.DPDKMode = true
+	case "ovs-dpdk":
+		if n.OvsDpdk.Bridge == "" {
+			n.OvsDpdk.Bridge = defaultOvsDpdkBridge
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode %q", n.Mode)
+	}
+
+	if n.SwitchdevRaw != nil {
+		n.Switchdev = *n.SwitchdevRaw
+	} else if n.Master != "" {
+		n.Switchdev = isSwitchdevMode(n.Master)
+	}
+
+	if n.Switchdev {
+		if n.Sharedvf {
+			return nil, fmt.Errorf("sharedvf is not supported together with switchdev mode on PF %q", n.Master)
+		}
+		if n.SwitchdevRaw != nil && *n.SwitchdevRaw && n.Master != "" && !isSwitchdevMode(n.Master) {
+			return nil, fmt.Errorf("switchdev mode was requested but PF %q is in legacy eswitch mode", n.Master)
+		}
+	}
+
+	if n.ForceResourceNameRaw != nil {
+		n.ForceResourceName = *n.ForceResourceNameRaw
+	} else {
+		n.ForceResourceName = defaultForceResourceName
+	}
+
+	if n.ForceResourceName && n.ResourceName == "" {
+		return nil, fmt.Errorf("forceResourceName is enabled but netconf does not carry a resourceName")
+	}
+
+	return n, nil
+}
+
+// resourcePoolForPF looks up the resource pool that pfName was handed out
+// under, as recorded by the sriov device plugin.
+func resourcePoolForPF(pfName string) (string, error) {
+	data, err := ioutil.ReadFile(resourcePoolConfigPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read resource pool mapping %q: %v", resourcePoolConfigPath, err)
+	}
+
+	var pools map[string]string
+	if err := json.Unmarshal(data, &pools); err != nil {
+		return "", fmt.Errorf("failed to parse resource pool mapping %q: %v", resourcePoolConfigPath, err)
+	}
+
+	pool, ok := pools[pfName]
+	if !ok {
+		return "", fmt.Errorf("PF %q has no recorded resource pool", pfName)
+	}
+	return pool, nil
+}
+
+// isSwitchdevMode reports whether the PF named pfName currently has its
+// eswitch in switchdev mode. Representor netdevs only exist for PFs running
+// in that mode, so presence of phys_switch_id on the PF itself is used as
+// the detection signal.
+func isSwitchdevMode(pfName string) bool {
+	id, err := ioutil.ReadFile(filepath.Join("/sys/class/net", pfName, "phys_switch_id"))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(id)) != ""
+}
+
+// AssignFreeVF walks the virtual functions of n.Master and populates
+// n.DeviceInfo with the first one that isn't already in use. It never
+// considers any PF other than n.Master: when ForceResourceName is set, that
+// restriction is exactly what makes it impossible for a misconfigured
+// NetworkAttachmentDefinition to silently grab a VF from the wrong pool.
+func AssignFreeVF(n *NetConf) error {
+	if n.ForceResourceName {
+		actual, err := resourcePoolForPF(n.Master)
+		if err != nil {
+			return fmt.Errorf("unable to verify resource pool binding for PF %q: %v", n.Master, err)
+		}
+		if actual != n.ResourceName {
+			return fmt.Errorf("PF %q belongs to resource pool %q, but netconf requested pool %q", n.Master, actual, n.ResourceName)
+		}
+	}
+
+	pfDir := filepath.Join("/sys/class/net", n.Master, "device")
+	entries, err := ioutil.ReadDir(pfDir)
+	if err != nil {
+		return fmt.Errorf("failed to read PF device dir %q: %v", pfDir, err)
+	}
+
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), "virtfn") {
+			continue
+		}
+
+		vfIDStr := strings.TrimPrefix(e.Name(), "virtfn")
+		vfid, err := strconv.Atoi(vfIDStr)
+		if err != nil {
+			continue
+		}
+
+		link := filepath.Join(pfDir, e.Name())
+		pciaddr, err := os.Readlink(link)
+		if err != nil {
+			continue
+		}
+		pciaddr = filepath.Base(pciaddr)
+
+		netDir := filepath.Join(pfDir, e.Name(), "net")
+		netEntries, err := ioutil.ReadDir(netDir)
+		if err != nil || len(netEntries) == 0 {
+			// no netdev bound to this VF; treat it as free
+			n.DeviceInfo = &VfInformation{
+				PCIaddr: pciaddr,
+				Pfname:  n.Master,
+				Vfid:    vfid,
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no free VF found on PF %q", n.Master)
+}
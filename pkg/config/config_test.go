@@ -0,0 +1,138 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withResourcePoolFixture points resourcePoolConfigPath at a temp file
+// containing pools and restores the real path on cleanup.
+func withResourcePoolFixture(t *testing.T, pools map[string]string) {
+	t.Helper()
+
+	data, err := json.Marshal(pools)
+	if err != nil {
+		t.Fatalf("failed to marshal fixture pools: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "resourcePools.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write fixture %q: %v", path, err)
+	}
+
+	orig := resourcePoolConfigPath
+	resourcePoolConfigPath = path
+	t.Cleanup(func() { resourcePoolConfigPath = orig })
+}
+
+func TestLoadConfForceResourceName(t *testing.T) {
+	cases := []struct {
+		name    string
+		netconf map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "disabled flag is backwards compatible without resourceName",
+			netconf: map[string]interface{}{
+				"master": "eth0",
+			},
+			wantErr: false,
+		},
+		{
+			name: "enabled with resourceName present",
+			netconf: map[string]interface{}{
+				"master":            "eth0",
+				"forceResourceName": true,
+				"resourceName":      "intel.com/sriov_vfio",
+			},
+			wantErr: false,
+		},
+		{
+			name: "enabled but resourceName missing",
+			netconf: map[string]interface{}{
+				"master":            "eth0",
+				"forceResourceName": true,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.netconf)
+			if err != nil {
+				t.Fatalf("failed to marshal netconf: %v", err)
+			}
+
+			_, err = LoadConf(data)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("LoadConf() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestAssignFreeVFRejectsPoolMismatch(t *testing.T) {
+	withResourcePoolFixture(t, map[string]string{"eth0": "intel.com/sriov_net"})
+
+	raw := true
+	n := &NetConf{
+		Master:               "eth0",
+		ResourceName:         "intel.com/sriov_vfio",
+		ForceResourceNameRaw: &raw,
+		ForceResourceName:    true,
+	}
+
+	err := AssignFreeVF(n)
+	if err == nil {
+		t.Fatal("AssignFreeVF() = nil, want error when the declared pool doesn't match the recorded one")
+	}
+	if want := "sriov_net"; !strings.Contains(err.Error(), want) {
+		t.Errorf("AssignFreeVF() error = %q, want it to name the actual pool %q", err, want)
+	}
+}
+
+func TestAssignFreeVFMissingPoolMapping(t *testing.T) {
+	withResourcePoolFixture(t, nil)
+	resourcePoolConfigPath = filepath.Join(t.TempDir(), "does-not-exist.json")
+
+	raw := true
+	n := &NetConf{
+		Master:               "eth0",
+		ResourceName:         "intel.com/sriov_vfio",
+		ForceResourceNameRaw: &raw,
+		ForceResourceName:    true,
+	}
+
+	// No fixture/recorded mapping at all for the PF: AssignFreeVF must fail
+	// closed rather than fall back to scanning eth0's VFs unchecked.
+	if err := AssignFreeVF(n); err == nil {
+		t.Error("AssignFreeVF() = nil, want error when the resource pool mapping can't be read")
+	}
+}
+
+func TestAssignFreeVFPoolMatchProceedsPastResourceCheck(t *testing.T) {
+	withResourcePoolFixture(t, map[string]string{"eth0": "intel.com/sriov_vfio"})
+
+	raw := true
+	n := &NetConf{
+		Master:               "eth0",
+		ResourceName:         "intel.com/sriov_vfio",
+		ForceResourceNameRaw: &raw,
+		ForceResourceName:    true,
+	}
+
+	// The pool matches, so AssignFreeVF must get past the ForceResourceName
+	// gate and fail for the next reason instead (no such PF/sysfs dir in
+	// the test environment), not the pool-mismatch error.
+	err := AssignFreeVF(n)
+	if err == nil {
+		t.Fatal("AssignFreeVF() = nil, want error: eth0 has no real sysfs device dir in this test environment")
+	}
+	if strings.Contains(err.Error(), "belongs to resource pool") {
+		t.Errorf("AssignFreeVF() error = %q, want the pool check to have passed", err)
+	}
+}
@@ -0,0 +1,121 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/current"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultDeadline is used when the netconf does not override it.
+const DefaultDeadline = 5 * time.Second
+
+const maxAllocateAttempts = 3
+
+// Dial connects to the IPAM daemon listening on the given unix socket, e.g.
+// "unix:///var/lib/cni/sriov-ipam.sock".
+func Dial(socket string) (*grpc.ClientConn, error) {
+	return grpc.Dial(socket, grpc.WithInsecure(), grpc.WithContextDialer(
+		func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
+		}))
+}
+
+// Allocate asks the IPAM daemon for an address for the given VF and returns
+// it as a current.Result, the same shape the exec-based IPAM plugins
+// return. On Unavailable (the daemon restarting, or the socket briefly not
+// accepting connections) it tries again, up to maxAllocateAttempts calls in
+// all.
+func Allocate(client IPAMClient, deadline time.Duration, req *AllocateRequest) (*current.Result, error) {
+	if deadline <= 0 {
+		deadline = DefaultDeadline
+	}
+
+	var resp *AllocateResponse
+	var err error
+	for attempt := 0; attempt < maxAllocateAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), deadline)
+		resp, err = client.Allocate(ctx, req)
+		cancel()
+
+		if err == nil {
+			break
+		}
+		if status.Code(err) != codes.Unavailable {
+			return nil, fmt.Errorf("grpc ipam: Allocate failed: %v", err)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("grpc ipam: Allocate failed after %d attempts: %v", maxAllocateAttempts, err)
+	}
+
+	return allocateResponseToResult(resp)
+}
+
+// Deallocate releases the address held for the given VF. A NotFound
+// response is treated as success: DEL must be idempotent, and the pool may
+// already have reclaimed it from a prior, partially-successful DEL.
+func Deallocate(client IPAMClient, deadline time.Duration, req *DeallocateRequest) error {
+	if deadline <= 0 {
+		deadline = DefaultDeadline
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+
+	_, err := client.Deallocate(ctx, req)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("grpc ipam: Deallocate failed: %v", err)
+	}
+	return nil
+}
+
+func allocateResponseToResult(resp *AllocateResponse) (*current.Result, error) {
+	result := &current.Result{}
+
+	for _, ip := range resp.Ips {
+		hostIP, ipNet, err := net.ParseCIDR(ip.Address)
+		if err != nil {
+			return nil, fmt.Errorf("grpc ipam: invalid address %q: %v", ip.Address, err)
+		}
+
+		version := ip.Version
+		if version == "" {
+			version = "4"
+			if hostIP.To4() == nil {
+				version = "6"
+			}
+		}
+
+		ipc := &current.IPConfig{
+			Version: version,
+			Address: net.IPNet{IP: hostIP, Mask: ipNet.Mask},
+		}
+		if ip.Gateway != "" {
+			ipc.Gateway = net.ParseIP(ip.Gateway)
+		}
+		result.IPs = append(result.IPs, ipc)
+	}
+
+	for _, r := range resp.Routes {
+		_, dst, err := net.ParseCIDR(r.Dst)
+		if err != nil {
+			return nil, fmt.Errorf("grpc ipam: invalid route destination %q: %v", r.Dst, err)
+		}
+		result.Routes = append(result.Routes, &current.Route{
+			Dst: *dst,
+			GW:  net.ParseIP(r.Gw),
+		})
+	}
+
+	result.DNS.Nameservers = resp.DnsNameservers
+	result.DNS.Search = resp.DnsSearch
+
+	return result, nil
+}
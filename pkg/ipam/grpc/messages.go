@@ -0,0 +1,66 @@
+// Package grpc implements the client side of the IPAM gRPC service
+// described by ipam.proto. The message types below are hand-written to
+// match that schema, not protoc-gen-go output: edit them and ipam.proto
+// together.
+package grpc
+
+import "fmt"
+
+type AllocateRequest struct {
+	ContainerId string `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	IfName      string `protobuf:"bytes,2,opt,name=if_name,json=ifName,proto3" json:"if_name,omitempty"`
+	Netns       string `protobuf:"bytes,3,opt,name=netns,proto3" json:"netns,omitempty"`
+	PoolName    string `protobuf:"bytes,4,opt,name=pool_name,json=poolName,proto3" json:"pool_name,omitempty"`
+	PciAddr     string `protobuf:"bytes,5,opt,name=pci_addr,json=pciAddr,proto3" json:"pci_addr,omitempty"`
+}
+
+func (m *AllocateRequest) Reset()         { *m = AllocateRequest{} }
+func (m *AllocateRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*AllocateRequest) ProtoMessage()    {}
+
+type IPConfig struct {
+	Version string `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Address string `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	Gateway string `protobuf:"bytes,3,opt,name=gateway,proto3" json:"gateway,omitempty"`
+}
+
+func (m *IPConfig) Reset()         { *m = IPConfig{} }
+func (m *IPConfig) String() string { return fmt.Sprintf("%+v", *m) }
+func (*IPConfig) ProtoMessage()    {}
+
+type Route struct {
+	Dst string `protobuf:"bytes,1,opt,name=dst,proto3" json:"dst,omitempty"`
+	Gw  string `protobuf:"bytes,2,opt,name=gw,proto3" json:"gw,omitempty"`
+}
+
+func (m *Route) Reset()         { *m = Route{} }
+func (m *Route) String() string { return fmt.Sprintf("%+v", *m) }
+func (*Route) ProtoMessage()    {}
+
+type AllocateResponse struct {
+	Ips            []*IPConfig `protobuf:"bytes,1,rep,name=ips,proto3" json:"ips,omitempty"`
+	Routes         []*Route    `protobuf:"bytes,2,rep,name=routes,proto3" json:"routes,omitempty"`
+	DnsNameservers []string    `protobuf:"bytes,3,rep,name=dns_nameservers,json=dnsNameservers,proto3" json:"dns_nameservers,omitempty"`
+	DnsSearch      []string    `protobuf:"bytes,4,rep,name=dns_search,json=dnsSearch,proto3" json:"dns_search,omitempty"`
+}
+
+func (m *AllocateResponse) Reset()         { *m = AllocateResponse{} }
+func (m *AllocateResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (*AllocateResponse) ProtoMessage()    {}
+
+type DeallocateRequest struct {
+	ContainerId string `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	IfName      string `protobuf:"bytes,2,opt,name=if_name,json=ifName,proto3" json:"if_name,omitempty"`
+	PoolName    string `protobuf:"bytes,3,opt,name=pool_name,json=poolName,proto3" json:"pool_name,omitempty"`
+}
+
+func (m *DeallocateRequest) Reset()         { *m = DeallocateRequest{} }
+func (m *DeallocateRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*DeallocateRequest) ProtoMessage()    {}
+
+type DeallocateResponse struct {
+}
+
+func (m *DeallocateResponse) Reset()         { *m = DeallocateResponse{} }
+func (m *DeallocateResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (*DeallocateResponse) ProtoMessage()    {}
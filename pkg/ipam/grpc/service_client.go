@@ -0,0 +1,42 @@
+// Hand-written client stub matching the IPAM service described by
+// ipam.proto, not protoc-gen-go-grpc output: edit it and ipam.proto
+// together.
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// IPAMClient is the client API for the IPAM service.
+type IPAMClient interface {
+	Allocate(ctx context.Context, in *AllocateRequest, opts ...grpc.CallOption) (*AllocateResponse, error)
+	Deallocate(ctx context.Context, in *DeallocateRequest, opts ...grpc.CallOption) (*DeallocateResponse, error)
+}
+
+type iPAMClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewIPAMClient wraps an established connection (e.g. over a unix socket)
+// in the IPAM client API.
+func NewIPAMClient(cc grpc.ClientConnInterface) IPAMClient {
+	return &iPAMClient{cc}
+}
+
+func (c *iPAMClient) Allocate(ctx context.Context, in *AllocateRequest, opts ...grpc.CallOption) (*AllocateResponse, error) {
+	out := new(AllocateResponse)
+	if err := c.cc.Invoke(ctx, "/grpcipam.IPAM/Allocate", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iPAMClient) Deallocate(ctx context.Context, in *DeallocateRequest, opts ...grpc.CallOption) (*DeallocateResponse, error) {
+	out := new(DeallocateResponse)
+	if err := c.cc.Invoke(ctx, "/grpcipam.IPAM/Deallocate", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	current "github.com/containernetworking/cni/pkg/types/current"
+	"github.com/intel/sriov-cni/pkg/config"
+)
+
+// cachedState is what gets persisted under CNIDir at ADD time. DEL reads it
+// back so that PF-side VF attributes can be restored even once
+// args.Netns no longer resolves to anything (e.g. after a node reboot).
+type cachedState struct {
+	NetConf *config.NetConf `json:"netConf"`
+	Result  *current.Result `json:"result,omitempty"`
+}
+
+func cacheFilePath(cniDir, containerID, ifName string) string {
+	return filepath.Join(cniDir, fmt.Sprintf("%s-%s.json", containerID, ifName))
+}
+
+// saveCachedState writes n and result, keyed by containerID+ifName, so a
+// later DEL (or CHECK) can recover the exact state ADD produced.
+func saveCachedState(n *config.NetConf, containerID, ifName string, result *current.Result) error {
+	if err := os.MkdirAll(n.CNIDir, 0700); err != nil {
+		return fmt.Errorf("failed to create the sriov data directory(%q): %v", n.CNIDir, err)
+	}
+
+	data, err := json.Marshal(cachedState{NetConf: n, Result: result})
+	if err != nil {
+		return fmt.Errorf("failed to marshal cached state: %v", err)
+	}
+
+	return ioutil.WriteFile(cacheFilePath(n.CNIDir, containerID, ifName), data, 0600)
+}
+
+// loadCachedState reads back what saveCachedState wrote. A missing file is
+// not an error: it just means there is nothing to restore (e.g. ADD never
+// completed, or DEL already ran once).
+func loadCachedState(cniDir, containerID, ifName string) (*cachedState, error) {
+	data, err := ioutil.ReadFile(cacheFilePath(cniDir, containerID, ifName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read cached state: %v", err)
+	}
+
+	cs := &cachedState{}
+	if err := json.Unmarshal(data, cs); err != nil {
+		return nil, fmt.Errorf("failed to parse cached state: %v", err)
+	}
+
+	return cs, nil
+}
+
+func deleteCachedState(cniDir, containerID, ifName string) error {
+	err := os.Remove(cacheFilePath(cniDir, containerID, ifName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	current "github.com/containernetworking/cni/pkg/types/current"
+	"github.com/intel/sriov-cni/pkg/config"
+)
+
+func TestSaveLoadCachedStateRoundTrip(t *testing.T) {
+	cniDir := t.TempDir()
+
+	minRate, maxRate := 10, 100
+	n := &config.NetConf{
+		CNIDir: cniDir,
+		Master: "eth0",
+		DeviceInfo: &config.VfInformation{
+			PCIaddr: "0000:03:00.1",
+			Pfname:  "eth0",
+			Vfid:    1,
+		},
+		MinTxRate: &minRate,
+		MaxTxRate: &maxRate,
+	}
+	result := &current.Result{
+		Interfaces: []*current.Interface{{Name: "net1", Sandbox: "/proc/1234/ns/net"}},
+		IPs: []*current.IPConfig{{
+			Version: "4",
+			Address: mustParseCIDR(t, "10.0.0.5/24"),
+		}},
+	}
+
+	if err := saveCachedState(n, "cid123", "net1", result); err != nil {
+		t.Fatalf("saveCachedState() = %v", err)
+	}
+
+	cached, err := loadCachedState(cniDir, "cid123", "net1")
+	if err != nil {
+		t.Fatalf("loadCachedState() = %v", err)
+	}
+	if cached == nil {
+		t.Fatal("loadCachedState() = nil, want the state just saved")
+	}
+
+	if cached.NetConf == nil || cached.NetConf.DeviceInfo == nil {
+		t.Fatal("loadCachedState() lost NetConf.DeviceInfo")
+	}
+	if got, want := cached.NetConf.DeviceInfo.PCIaddr, n.DeviceInfo.PCIaddr; got != want {
+		t.Errorf("DeviceInfo.PCIaddr = %q, want %q", got, want)
+	}
+	if cached.NetConf.MinTxRate == nil || *cached.NetConf.MinTxRate != minRate {
+		t.Errorf("MinTxRate = %v, want %d", cached.NetConf.MinTxRate, minRate)
+	}
+	if cached.NetConf.MaxTxRate == nil || *cached.NetConf.MaxTxRate != maxRate {
+		t.Errorf("MaxTxRate = %v, want %d", cached.NetConf.MaxTxRate, maxRate)
+	}
+
+	if cached.Result == nil || len(cached.Result.IPs) != 1 {
+		t.Fatal("loadCachedState() lost Result.IPs")
+	}
+	if got, want := cached.Result.IPs[0].Address.String(), "10.0.0.5/24"; got != want {
+		t.Errorf("Result.IPs[0].Address = %q, want %q", got, want)
+	}
+
+	if err := deleteCachedState(cniDir, "cid123", "net1"); err != nil {
+		t.Fatalf("deleteCachedState() = %v", err)
+	}
+	cached, err = loadCachedState(cniDir, "cid123", "net1")
+	if err != nil {
+		t.Fatalf("loadCachedState() after delete = %v", err)
+	}
+	if cached != nil {
+		t.Error("loadCachedState() after delete = non-nil, want nil")
+	}
+}
+
+func TestLoadCachedStateMissingFileIsNotError(t *testing.T) {
+	cached, err := loadCachedState(t.TempDir(), "cid123", "net1")
+	if err != nil {
+		t.Fatalf("loadCachedState() = %v, want nil error for a missing file", err)
+	}
+	if cached != nil {
+		t.Errorf("loadCachedState() = %+v, want nil", cached)
+	}
+}
+
+func mustParseCIDR(t *testing.T, s string) net.IPNet {
+	t.Helper()
+	ip, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("invalid CIDR %q: %v", s, err)
+	}
+	return net.IPNet{IP: ip, Mask: ipNet.Mask}
+}
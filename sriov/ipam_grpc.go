@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/current"
+	"github.com/intel/sriov-cni/pkg/config"
+	grpcipam "github.com/intel/sriov-cni/pkg/ipam/grpc"
+)
+
+// grpcIPAMDeadline parses n.IPAM.Deadline, falling back to
+// grpcipam.DefaultDeadline when unset or unparsable.
+func grpcIPAMDeadline(n *config.NetConf) time.Duration {
+	if n.IPAM.Deadline == "" {
+		return grpcipam.DefaultDeadline
+	}
+	d, err := time.ParseDuration(n.IPAM.Deadline)
+	if err != nil {
+		return grpcipam.DefaultDeadline
+	}
+	return d
+}
+
+// grpcIPAMAllocate dials the daemon named by n.IPAM.Socket and requests an
+// address for the resolved VF.
+func grpcIPAMAllocate(n *config.NetConf, args *skel.CmdArgs) (*current.Result, error) {
+	if n.IPAM.Socket == "" {
+		return nil, fmt.Errorf("grpc ipam: netconf is missing ipam.socket")
+	}
+
+	conn, err := grpcipam.Dial(n.IPAM.Socket)
+	if err != nil {
+		return nil, fmt.Errorf("grpc ipam: failed to dial %q: %v", n.IPAM.Socket, err)
+	}
+	defer conn.Close()
+
+	client := grpcipam.NewIPAMClient(conn)
+	return grpcipam.Allocate(client, grpcIPAMDeadline(n), &grpcipam.AllocateRequest{
+		ContainerId: args.ContainerID,
+		IfName:      args.IfName,
+		Netns:       args.Netns,
+		PoolName:    n.IPAM.PoolName,
+		PciAddr:     n.DeviceInfo.PCIaddr,
+	})
+}
+
+// grpcIPAMDeallocate is the DEL-side counterpart of grpcIPAMAllocate.
+func grpcIPAMDeallocate(n *config.NetConf, args *skel.CmdArgs) error {
+	if n.IPAM.Socket == "" {
+		return fmt.Errorf("grpc ipam: netconf is missing ipam.socket")
+	}
+
+	conn, err := grpcipam.Dial(n.IPAM.Socket)
+	if err != nil {
+		return fmt.Errorf("grpc ipam: failed to dial %q: %v", n.IPAM.Socket, err)
+	}
+	defer conn.Close()
+
+	client := grpcipam.NewIPAMClient(conn)
+	return grpcipam.Deallocate(client, grpcIPAMDeadline(n), &grpcipam.DeallocateRequest{
+		ContainerId: args.ContainerID,
+		IfName:      args.IfName,
+		PoolName:    n.IPAM.PoolName,
+	})
+}
@@ -1,21 +1,18 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"runtime"
-	"strings"
 
 	"github.com/containernetworking/cni/pkg/ipam"
 	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/current"
 	"github.com/containernetworking/cni/pkg/version"
 	"github.com/intel/sriov-cni/pkg/config"
+	"github.com/vishvananda/netlink"
 )
 
 func init() {
@@ -33,7 +30,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", netns, err)
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
 	}
 	defer netns.Close()
 
@@ -60,70 +57,158 @@ func cmdAdd(args *skel.CmdArgs) error {
 		n.DPDKConf.VFID = n.DeviceInfo.Vfid
 	}
 
-	if n.DeviceInfo != nil && n.DeviceInfo.PCIaddr != "" && n.DeviceInfo.Vfid >= 0 && n.DeviceInfo.Pfname != "" {
+	if n.DeviceInfo == nil || n.DeviceInfo.PCIaddr == "" || n.DeviceInfo.Vfid < 0 || n.DeviceInfo.Pfname == "" {
+		return fmt.Errorf("VF information are not available to invoke setupVF()")
+	}
+
+	result := &current.Result{}
+
+	if n.Mode == "ovs-dpdk" {
+		if err := setupOvsDpdkVF(n, args.IfName, args.ContainerID); err != nil {
+			return fmt.Errorf("failed to attach VF to ovs-dpdk bridge %q: %v", n.OvsDpdk.Bridge, err)
+		}
+	} else {
 		if err = setupVF(n, args.IfName, args.ContainerID, netns); err != nil {
 			return fmt.Errorf("failed to set up pod interface %q from the device %q: %v", args.IfName, n.Master, err)
 		}
-	} else {
-		return fmt.Errorf("VF information are not available to invoke setupVF()")
+
+		result.Interfaces = []*current.Interface{{
+			Name:    args.IfName,
+			Sandbox: netns.Path(),
+		}}
+
+		if n.Switchdev {
+			rep, err := setupVFRepresentor(n, args.IfName, args.ContainerID)
+			if err != nil {
+				return fmt.Errorf("failed to resolve VF representor for %q: %v", args.IfName, err)
+			}
+
+			// Surface the representor as a host-side interface (empty
+			// Sandbox) in the CNI result so a companion controller (e.g.
+			// OVS hardware-offload) can read it back without reaching
+			// into our private state file.
+			result.Interfaces = append(result.Interfaces, &current.Interface{Name: rep})
+		}
 	}
 
 	// skip the IPAM allocation for L2 mode
-	var result *types.Result
 	if n.L2Mode {
-		return result.Print()
+		if err := saveCachedState(n, args.ContainerID, args.IfName, result); err != nil {
+			return err
+		}
+		return types.PrintResult(result, n.CNIVersion)
 	}
 
-	// experimental: run IPAM allocation for DPDK mode
-	if n.DPDKMode && n.IPAM.Type != "" {
-		result, err = ipam.ExecAdd(n.IPAM.Type, args.StdinData)
-		if err != nil {
-			return fmt.Errorf("failed to set up IPAM plugin type %q from the device %q: %v", n.IPAM.Type, n.Master, err)
+	// run the IPAM plugin and get back the config to apply. This now
+	// covers the DPDK datapath too: it used to skip IPAM release on DEL,
+	// which this shared path fixes by construction.
+	if n.IPAM.Type != "" {
+		var newResult *current.Result
+		if n.IPAM.Type == "grpc" {
+			newResult, err = grpcIPAMAllocate(n, args)
+		} else {
+			var ipamResult types.Result
+			ipamResult, err = ipam.ExecAdd(n.IPAM.Type, args.StdinData)
+			if err == nil {
+				newResult, err = current.NewResultFromResult(ipamResult)
+			}
 		}
-		result, err = ipam.ExecAdd(n.IPAM.Type, args.StdinData)
 		if err != nil {
 			return fmt.Errorf("failed to set up IPAM plugin type %q from the device %q: %v", n.IPAM.Type, n.Master, err)
 		}
-		result.DNS = n.DNS
-
-		// WIP save to file
 
-		ipamResult, _ := json.Marshal(result)
-		s := []string{args.ContainerID, n.DPDKConf.Ifname, "ipam"}
-		filename := strings.Join(s, "-")
-		if err := os.MkdirAll(n.CNIDir, 0700); err != nil {
-			return fmt.Errorf("failed to create the sriov data directory(%q): %v", n.CNIDir, err)
+		if len(newResult.IPs) == 0 {
+			return errors.New("IPAM plugin returned missing IP config")
+		}
+		newResult.Interfaces = result.Interfaces
+		for _, ip := range newResult.IPs {
+			ip.Interface = current.Int(0)
 		}
-		path := filepath.Join(n.CNIDir, filename)
+		newResult.DNS = n.DNS
+		result = newResult
 
-		err := ioutil.WriteFile(path, ipamResult, 0600)
-		if err != nil {
-			return fmt.Errorf("failed to write container data in the path(%q): %v", path, err)
+		if !n.DPDKMode && n.Mode != "ovs-dpdk" {
+			if err := netns.Do(func(_ ns.NetNS) error {
+				return ipam.ConfigureIface(args.IfName, result)
+			}); err != nil {
+				return err
+			}
 		}
-		return result.Print()
 	}
 
-	// run the IPAM plugin and get back the config to apply
-	if !n.DPDKMode {
-		result, err = ipam.ExecAdd(n.IPAM.Type, args.StdinData)
+	if err := saveCachedState(n, args.ContainerID, args.IfName, result); err != nil {
+		return err
+	}
+
+	return types.PrintResult(result, n.CNIVersion)
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := config.LoadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if n.IF0NAME != "" {
+		args.IfName = n.IF0NAME
+	}
+
+	cached, err := loadCachedState(n.CNIDir, args.ContainerID, args.IfName)
+	if err != nil {
+		return err
+	}
+	if cached == nil {
+		return fmt.Errorf("sriov-cni CHECK: no cached state for container %q interface %q", args.ContainerID, args.IfName)
+	}
+
+	if args.Netns == "" {
+		return nil
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	return netns.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(args.IfName)
 		if err != nil {
-			return fmt.Errorf("failed to set up IPAM plugin type %q from the device %q: %v", n.IPAM.Type, n.Master, err)
+			return fmt.Errorf("sriov-cni CHECK: failed to find link %q in container netns: %v", args.IfName, err)
+		}
+
+		if n.MAC != "" && link.Attrs().HardwareAddr.String() != n.MAC {
+			return fmt.Errorf("sriov-cni CHECK: expected MAC %q on %q, got %q", n.MAC, args.IfName, link.Attrs().HardwareAddr)
 		}
 
-		if result.IP4 == nil {
-			return errors.New("IPAM plugin returned missing IPv4 config")
+		if n.Mtu != 0 && link.Attrs().MTU != n.Mtu {
+			return fmt.Errorf("sriov-cni CHECK: expected MTU %d on %q, got %d", n.Mtu, args.IfName, link.Attrs().MTU)
 		}
 
-		err = netns.Do(func(_ ns.NetNS) error {
-			return ipam.ConfigureIface(args.IfName, result)
-		})
+		if cached.Result == nil || len(cached.Result.IPs) == 0 {
+			return nil
+		}
+
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
 		if err != nil {
-			return err
+			return fmt.Errorf("sriov-cni CHECK: failed to list addresses on %q: %v", args.IfName, err)
 		}
-		result.DNS = n.DNS
-	}
 
-	return result.Print()
+		for _, wantIP := range cached.Result.IPs {
+			found := false
+			for _, addr := range addrs {
+				if addr.IPNet != nil && addr.IPNet.IP.Equal(wantIP.Address.IP) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("sriov-cni CHECK: expected IP %s on %q, not found", wantIP.Address.IP, args.IfName)
+			}
+		}
+
+		return nil
+	})
 }
 
 func cmdDel(args *skel.CmdArgs) error {
@@ -137,41 +222,74 @@ func cmdDel(args *skel.CmdArgs) error {
 	}
 
 	// skip the IPAM release for L2 mode
-	// TODO: what about DPDKMode?
 	if !n.L2Mode && n.IPAM.Type != "" {
-		err = ipam.ExecDel(n.IPAM.Type, args.StdinData)
-		if err != nil {
+		if n.IPAM.Type == "grpc" {
+			if err := grpcIPAMDeallocate(n, args); err != nil {
+				return err
+			}
+		} else if err := ipam.ExecDel(n.IPAM.Type, args.StdinData); err != nil {
 			return err
 		}
 	}
 
-	if args.Netns == "" {
+	// Consult the cache so PF-side attributes can still be reversed once
+	// args.Netns is gone, and so a DEL carrying a thinner netconf than
+	// ADD (e.g. Master-only) still targets the right VF.
+	cached, err := loadCachedState(n.CNIDir, args.ContainerID, args.IfName)
+	if err != nil {
+		return err
+	}
+	if cached != nil && cached.NetConf != nil {
+		if n.DeviceInfo == nil {
+			n.DeviceInfo = cached.NetConf.DeviceInfo
+		}
+		// Reset what ADD programmed on the PF, not what this DEL netconf
+		// happens to carry.
+		n.ExternallyManaged = cached.NetConf.ExternallyManaged
+		n.Vlan = cached.NetConf.Vlan
+		n.MAC = cached.NetConf.MAC
+		n.MinTxRate = cached.NetConf.MinTxRate
+		n.MaxTxRate = cached.NetConf.MaxTxRate
+		n.LinkState = cached.NetConf.LinkState
+	}
+	defer deleteCachedState(n.CNIDir, args.ContainerID, args.IfName)
+
+	if n.Mode == "ovs-dpdk" {
+		return releaseOvsDpdkVF(n, args.IfName, args.ContainerID)
+	}
+
+	if n.Switchdev {
+		if err := releaseVFRepresentor(n, args.IfName, args.ContainerID); err != nil {
+			return err
+		}
+	}
+
+	if n.DeviceInfo == nil {
 		return nil
 	}
 
+	if args.Netns == "" {
+		return resetVFConfig(n)
+	}
+
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
 		// according to:
 		// https://github.com/kubernetes/kubernetes/issues/43014#issuecomment-287164444
 		// if provided path does not exist (e.x. when node was restarted)
-		// plugin should silently return with success after releasing
-		// IPAM resources
-		_, ok := err.(ns.NSPathNotExistErr)
-		if ok {
-			return nil
+		// plugin should silently release PF-side VF state and return
+		// success
+		if _, ok := err.(ns.NSPathNotExistErr); ok {
+			return resetVFConfig(n)
 		}
 
-		return fmt.Errorf("failed to open netns %q: %v", netns, err)
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
 	}
 	defer netns.Close()
 
-	if err = releaseVF(n, args.IfName, args.ContainerID, netns); err != nil {
-		return err
-	}
-
-	return nil
+	return releaseVF(n, args.IfName, args.ContainerID, netns)
 }
 
 func main() {
-	skel.PluginMain(cmdAdd, cmdDel, version.Legacy)
+	skel.PluginMain(cmdAdd, cmdCheck, cmdDel, version.PluginSupports("0.3.0", "0.3.1", "0.4.0"), "")
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/intel/sriov-cni/pkg/config"
+)
+
+// ovsDpdkState is the information persisted at ADD time so that DEL can
+// remove the OVS port even once the container netns is long gone.
+type ovsDpdkState struct {
+	Bridge  string `json:"bridge"`
+	Port    string `json:"port"`
+	PCIaddr string `json:"pciAddr"`
+}
+
+func ovsDpdkStatePath(n *config.NetConf, containerID, ifName string) string {
+	return filepath.Join(n.CNIDir, fmt.Sprintf("%s-%s-ovsdpdk", containerID, ifName))
+}
+
+// ovsDpdkPortName derives a stable, OVS-friendly port name from the
+// container ID and interface name so repeated invocations are idempotent.
+// A pod sandbox can have several sriov-cni attachments (e.g. "net1",
+// "net2" under multus), so both the container ID and the interface name
+// must survive into the name; hashing rather than truncating avoids
+// collisions that a blind prefix cut would cause once the 64-hex-char
+// container ID alone exceeds the kernel's IFNAMSIZ-1 limit.
+func ovsDpdkPortName(containerID, ifName string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(containerID + "/" + ifName))
+	return fmt.Sprintf("dpdk%010x", h.Sum64()&0xFFFFFFFFFF)
+}
+
+// setupOvsDpdkVF attaches the resolved VF to n.OvsDpdk.Bridge as a dpdk
+// port bound to its PCI address, instead of moving the netdev into the
+// container netns. With n.OvsDpdk.VhostUser set it instead adds a
+// dpdkvhostuser/dpdkvhostuserclient port, and the VF's PCI address is not
+// passed to OVS. It persists the result so releaseOvsDpdkVF can reverse
+// it later.
+func setupOvsDpdkVF(n *config.NetConf, ifName, containerID string) error {
+	port := ovsDpdkPortName(containerID, ifName)
+	portType := "dpdk"
+	switch {
+	case n.OvsDpdk.VhostUser && n.OvsDpdk.VhostUserSocketDir != "":
+		// dpdkvhostuserclient lets us pin the socket path per port via
+		// vhost-server-path; plain dpdkvhostuser only honours a
+		// vswitchd-wide socket dir, which this netconf field can't reach.
+		portType = "dpdkvhostuserclient"
+	case n.OvsDpdk.VhostUser:
+		portType = "dpdkvhostuser"
+	}
+
+	args := []string{"add-port", n.OvsDpdk.Bridge, port,
+		"--", "set", "Interface", port, "type=" + portType}
+	switch portType {
+	case "dpdk":
+		args = append(args, fmt.Sprintf("options:dpdk-devargs=%s", n.DeviceInfo.PCIaddr))
+	case "dpdkvhostuserclient":
+		socketPath := filepath.Join(n.OvsDpdk.VhostUserSocketDir, port)
+		args = append(args, fmt.Sprintf("options:vhost-server-path=%s", socketPath))
+	}
+
+	if out, err := exec.Command("ovs-vsctl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ovs-vsctl add-port %q %q failed: %v: %s", n.OvsDpdk.Bridge, port, err, out)
+	}
+
+	if err := os.MkdirAll(n.CNIDir, 0700); err != nil {
+		return fmt.Errorf("failed to create the sriov data directory(%q): %v", n.CNIDir, err)
+	}
+
+	state := ovsDpdkState{
+		Bridge:  n.OvsDpdk.Bridge,
+		Port:    port,
+		PCIaddr: n.DeviceInfo.PCIaddr,
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ovs-dpdk state: %v", err)
+	}
+
+	if err := ioutil.WriteFile(ovsDpdkStatePath(n, containerID, ifName), data, 0600); err != nil {
+		return fmt.Errorf("failed to persist ovs-dpdk state: %v", err)
+	}
+
+	return nil
+}
+
+// releaseOvsDpdkVF removes the OVS port created by setupOvsDpdkVF, reading
+// back the bridge/port name from the state file since n.DeviceInfo may not
+// be resolvable anymore (e.g. the PF has since been reassigned).
+func releaseOvsDpdkVF(n *config.NetConf, ifName, containerID string) error {
+	path := ovsDpdkStatePath(n, containerID, ifName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Nothing to do; DEL may be retried after a previous
+			// success already cleaned this up.
+			return nil
+		}
+		return fmt.Errorf("failed to read ovs-dpdk state %q: %v", path, err)
+	}
+
+	var state ovsDpdkState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse ovs-dpdk state %q: %v", path, err)
+	}
+
+	if out, err := exec.Command("ovs-vsctl", "del-port", state.Bridge, state.Port).CombinedOutput(); err != nil {
+		return fmt.Errorf("ovs-vsctl del-port %q %q failed: %v: %s", state.Bridge, state.Port, err, out)
+	}
+
+	return os.Remove(path)
+}
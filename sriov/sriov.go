@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/intel/sriov-cni/pkg/config"
+	"github.com/vishvananda/netlink"
+)
+
+// externallyManaged reports whether n.ExternallyManaged means the PF-side VF
+// attributes (MAC/VLAN/spoofchk/trust/rate/link-state) must be left
+// untouched. setupVF and resetVFConfig both gate on this single definition
+// so the two stay in sync.
+func externallyManaged(n *config.NetConf) bool {
+	return n.ExternallyManaged
+}
+
+// applyVFAttrsUnlessExternallyManaged calls configureVFAttrs, unless n says
+// the VF's PF-side attributes are owned outside this CNI, in which case it
+// is a deliberate no-op. setupVF calls this instead of configureVFAttrs
+// directly so the gate has exactly one call site to test and keep in sync.
+func applyVFAttrsUnlessExternallyManaged(n *config.NetConf, vfLink netlink.Link, vfLinkName string) error {
+	if externallyManaged(n) {
+		return nil
+	}
+	return configureVFAttrs(n, vfLink, vfLinkName)
+}
+
+// getVFLinkName returns the netdev name currently bound to the VF described
+// by n.DeviceInfo, as seen from the root namespace. Plain VF netdevs don't
+// report their PF as IFLA_LINK the way stacked devices (vlan, macvlan, ...)
+// do, so this resolves via the VF's PCI address in sysfs instead, the same
+// mechanism config.AssignFreeVF uses to find it in the first place.
+func getVFLinkName(n *config.NetConf) (string, error) {
+	netDir := filepath.Join("/sys/bus/pci/devices", n.DeviceInfo.PCIaddr, "net")
+	entries, err := ioutil.ReadDir(netDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read net dir for VF %s: %v", n.DeviceInfo.PCIaddr, err)
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("no netdev bound to VF %s", n.DeviceInfo.PCIaddr)
+	}
+
+	return entries[0].Name(), nil
+}
+
+// setupVF moves the VF netdev into the container netns, renames it to
+// ifName and, unless n.ExternallyManaged is set, applies the netconf's
+// PF-side attributes (MAC, VLAN, spoofchk, trust, rate, link-state) via
+// netlink.
+func setupVF(n *config.NetConf, ifName string, containerID string, netns ns.NetNS) error {
+	vfLinkName, err := getVFLinkName(n)
+	if err != nil {
+		return err
+	}
+
+	vfLink, err := netlink.LinkByName(vfLinkName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup VF netdev %q: %v", vfLinkName, err)
+	}
+
+	if err := applyVFAttrsUnlessExternallyManaged(n, vfLink, vfLinkName); err != nil {
+		return err
+	}
+
+	if err := netlink.LinkSetUp(vfLink); err != nil {
+		return fmt.Errorf("failed to set %q up: %v", vfLinkName, err)
+	}
+
+	if err := netlink.LinkSetNsFd(vfLink, int(netns.Fd())); err != nil {
+		return fmt.Errorf("failed to move %q to container netns: %v", vfLinkName, err)
+	}
+
+	return netns.Do(func(_ ns.NetNS) error {
+		contLink, err := netlink.LinkByName(vfLinkName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q in container netns: %v", vfLinkName, err)
+		}
+		if err := netlink.LinkSetName(contLink, ifName); err != nil {
+			return fmt.Errorf("failed to rename %q to %q: %v", vfLinkName, ifName, err)
+		}
+		if n.Mtu != 0 {
+			if err := netlink.LinkSetMTU(contLink, n.Mtu); err != nil {
+				return fmt.Errorf("failed to set MTU %d on %q: %v", n.Mtu, ifName, err)
+			}
+		}
+		return netlink.LinkSetUp(contLink)
+	})
+}
+
+// releaseVF moves the VF netdev for ifName back out of the container netns
+// to the root namespace, restoring its original name.
+func releaseVF(n *config.NetConf, ifName string, containerID string, netns ns.NetNS) error {
+	initns, err := ns.GetCurrentNS()
+	if err != nil {
+		return fmt.Errorf("failed to get root network namespace: %v", err)
+	}
+	defer initns.Close()
+
+	return netns.Do(func(_ ns.NetNS) error {
+		contLink, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q in container netns: %v", ifName, err)
+		}
+
+		if err := netlink.LinkSetDown(contLink); err != nil {
+			return fmt.Errorf("failed to set %q down: %v", ifName, err)
+		}
+
+		vfLinkName, err := getVFLinkName(n)
+		if err != nil {
+			return err
+		}
+
+		if err := netlink.LinkSetName(contLink, vfLinkName); err != nil {
+			return fmt.Errorf("failed to rename %q to %q: %v", ifName, vfLinkName, err)
+		}
+
+		if err := netlink.LinkSetNsFd(contLink, int(initns.Fd())); err != nil {
+			return fmt.Errorf("failed to move %q to root netns: %v", vfLinkName, err)
+		}
+
+		return nil
+	})
+}
+
+// configureVFAttrs programs the PF-side attributes of the VF described by
+// n.DeviceInfo: MAC, VLAN, spoofchk, trust, min/max tx rate and link-state.
+// It is only called when the VF is not externally managed.
+func configureVFAttrs(n *config.NetConf, vfLink netlink.Link, vfLinkName string) error {
+	if n.MAC != "" {
+		hwaddr, err := net.ParseMAC(n.MAC)
+		if err != nil {
+			return fmt.Errorf("failed to parse MAC address %q: %v", n.MAC, err)
+		}
+		if err := netlink.LinkSetHardwareAddr(vfLink, hwaddr); err != nil {
+			return fmt.Errorf("failed to set MAC address %q on %q: %v", n.MAC, vfLinkName, err)
+		}
+	}
+
+	pfLink, err := netlink.LinkByName(n.DeviceInfo.Pfname)
+	if err != nil {
+		return fmt.Errorf("failed to lookup PF %q: %v", n.DeviceInfo.Pfname, err)
+	}
+	vfid := n.DeviceInfo.Vfid
+
+	if n.Vlan != 0 {
+		if err := netlink.LinkSetVfVlan(pfLink, vfid, n.Vlan); err != nil {
+			return fmt.Errorf("failed to set VF %d vlan to %d: %v", vfid, n.Vlan, err)
+		}
+	}
+
+	if n.Spoofchk != "" {
+		if err := netlink.LinkSetVfSpoofchk(pfLink, vfid, n.Spoofchk == "on"); err != nil {
+			return fmt.Errorf("failed to set VF %d spoofchk to %q: %v", vfid, n.Spoofchk, err)
+		}
+	}
+
+	if n.Trust != "" {
+		if err := netlink.LinkSetVfTrust(pfLink, vfid, n.Trust == "on"); err != nil {
+			return fmt.Errorf("failed to set VF %d trust to %q: %v", vfid, n.Trust, err)
+		}
+	}
+
+	if n.MinTxRate != nil || n.MaxTxRate != nil {
+		minRate, maxRate := 0, 0
+		if n.MinTxRate != nil {
+			minRate = *n.MinTxRate
+		}
+		if n.MaxTxRate != nil {
+			maxRate = *n.MaxTxRate
+		}
+		if err := netlink.LinkSetVfRate(pfLink, vfid, minRate, maxRate); err != nil {
+			return fmt.Errorf("failed to set VF %d tx rate [%d,%d]: %v", vfid, minRate, maxRate, err)
+		}
+	}
+
+	if n.LinkState != "" {
+		state, err := vfLinkStateFromString(n.LinkState)
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetVfState(pfLink, vfid, state); err != nil {
+			return fmt.Errorf("failed to set VF %d link-state to %q: %v", vfid, n.LinkState, err)
+		}
+	}
+
+	return nil
+}
+
+// resetVFConfig restores a VF's PF-side VLAN, MAC, tx rate and link-state
+// to their neutral defaults, each only if n sets it; spoofchk and trust are
+// left as they are. It is used by DEL when the container netns is already gone, so
+// releaseVF cannot rename/move the netdev back, but the VF itself must
+// still be returned to a clean state for reuse by the next pod.
+func resetVFConfig(n *config.NetConf) error {
+	if externallyManaged(n) {
+		return nil
+	}
+
+	pfLink, err := netlink.LinkByName(n.DeviceInfo.Pfname)
+	if err != nil {
+		return fmt.Errorf("failed to lookup PF %q: %v", n.DeviceInfo.Pfname, err)
+	}
+	vfid := n.DeviceInfo.Vfid
+
+	if n.Vlan != 0 {
+		if err := netlink.LinkSetVfVlan(pfLink, vfid, 0); err != nil {
+			return fmt.Errorf("failed to reset VF %d vlan: %v", vfid, err)
+		}
+	}
+
+	if n.MAC != "" {
+		if err := netlink.LinkSetVfHardwareAddr(pfLink, vfid, make(net.HardwareAddr, 6)); err != nil {
+			return fmt.Errorf("failed to reset VF %d MAC: %v", vfid, err)
+		}
+	}
+
+	if n.MinTxRate != nil || n.MaxTxRate != nil {
+		if err := netlink.LinkSetVfRate(pfLink, vfid, 0, 0); err != nil {
+			return fmt.Errorf("failed to reset VF %d tx rate: %v", vfid, err)
+		}
+	}
+
+	if n.LinkState != "" {
+		if err := netlink.LinkSetVfState(pfLink, vfid, netlink.VF_LINK_STATE_AUTO); err != nil {
+			return fmt.Errorf("failed to reset VF %d link-state: %v", vfid, err)
+		}
+	}
+
+	return nil
+}
+
+func vfLinkStateFromString(s string) (uint32, error) {
+	switch s {
+	case "auto":
+		return netlink.VF_LINK_STATE_AUTO, nil
+	case "enable":
+		return netlink.VF_LINK_STATE_ENABLE, nil
+	case "disable":
+		return netlink.VF_LINK_STATE_DISABLE, nil
+	default:
+		return 0, fmt.Errorf("unknown link_state %q", s)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/intel/sriov-cni/pkg/config"
+	"github.com/vishvananda/netlink"
+)
+
+// withDummyPF creates a dummy link to stand in for a PF and returns it
+// along with a teardown func. Dummy links don't support VF subcommands, so
+// any netlink.LinkSetVf* call against one fails with a real kernel error —
+// which is exactly the signal these tests use to prove a PF-side write was
+// (or wasn't) attempted, without needing real SR-IOV hardware.
+func withDummyPF(t *testing.T) (netlink.Link, func()) {
+	t.Helper()
+
+	name := "sriovtestpf0"
+	link := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name}}
+	if err := netlink.LinkAdd(link); err != nil {
+		t.Skipf("cannot create dummy link (need CAP_NET_ADMIN): %v", err)
+	}
+
+	pfLink, err := netlink.LinkByName(name)
+	if err != nil {
+		netlink.LinkDel(link)
+		t.Fatalf("failed to look up dummy link %q: %v", name, err)
+	}
+
+	return pfLink, func() { netlink.LinkDel(link) }
+}
+
+func TestApplyVFAttrsUnlessExternallyManagedSkipsPFWrites(t *testing.T) {
+	pfLink, teardown := withDummyPF(t)
+	defer teardown()
+
+	n := &config.NetConf{
+		ExternallyManaged: true,
+		Vlan:              100,
+		DeviceInfo:        &config.VfInformation{Pfname: pfLink.Attrs().Name, Vfid: 0},
+	}
+
+	if err := applyVFAttrsUnlessExternallyManaged(n, pfLink, pfLink.Attrs().Name); err != nil {
+		t.Errorf("applyVFAttrsUnlessExternallyManaged() = %v, want nil: externally managed VFs must not touch the PF", err)
+	}
+}
+
+func TestApplyVFAttrsUnlessExternallyManagedWritesWhenNotManaged(t *testing.T) {
+	pfLink, teardown := withDummyPF(t)
+	defer teardown()
+
+	n := &config.NetConf{
+		ExternallyManaged: false,
+		Vlan:              100,
+		DeviceInfo:        &config.VfInformation{Pfname: pfLink.Attrs().Name, Vfid: 0},
+	}
+
+	// A dummy link has no VF subfunctions, so setting its "VF" vlan must
+	// fail at the kernel if configureVFAttrs was actually invoked. Success
+	// here would mean the write was silently skipped.
+	if err := applyVFAttrsUnlessExternallyManaged(n, pfLink, pfLink.Attrs().Name); err == nil {
+		t.Error("applyVFAttrsUnlessExternallyManaged() = nil, want an error: PF-side vlan write should have been attempted against a non-VF-capable link")
+	}
+}
+
+func TestResetVFConfigSkipsPFWritesWhenExternallyManaged(t *testing.T) {
+	pfLink, teardown := withDummyPF(t)
+	defer teardown()
+
+	n := &config.NetConf{
+		ExternallyManaged: true,
+		Vlan:              100,
+		DeviceInfo:        &config.VfInformation{Pfname: pfLink.Attrs().Name, Vfid: 0},
+	}
+
+	if err := resetVFConfig(n); err != nil {
+		t.Errorf("resetVFConfig() = %v, want nil: externally managed VFs must not touch the PF", err)
+	}
+}
+
+func TestResetVFConfigWritesWhenNotExternallyManaged(t *testing.T) {
+	pfLink, teardown := withDummyPF(t)
+	defer teardown()
+
+	n := &config.NetConf{
+		ExternallyManaged: false,
+		Vlan:              100,
+		DeviceInfo:        &config.VfInformation{Pfname: pfLink.Attrs().Name, Vfid: 0},
+	}
+
+	if err := resetVFConfig(n); err == nil {
+		t.Error("resetVFConfig() = nil, want an error: PF-side vlan reset should have been attempted against a non-VF-capable link")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/intel/sriov-cni/pkg/config"
+)
+
+// vfRepresentorPortName matches the kernel's "pf<N>vf<M>" phys_port_name
+// convention for VF representors. The PF index N varies by card (a
+// dual/quad-port NIC is never pf0 for every port), so it is deliberately
+// left unconstrained here; phys_switch_id has already narrowed the search
+// to representors of this PF's own ASIC, so matching on the VF id alone is
+// enough to pick the right one.
+var vfRepresentorPortName = regexp.MustCompile(`^pf\d+vf(\d+)$`)
+
+// switchdevState is persisted at ADD time so releaseVFRepresentor can
+// reverse the ovsBridge attachment on DEL without needing the container
+// netns, which no longer exists for representors anyway.
+type switchdevState struct {
+	Representor string `json:"representor"`
+	OvsBridge   string `json:"ovsBridge"`
+}
+
+func switchdevStatePath(n *config.NetConf, containerID, ifName string) string {
+	return filepath.Join(n.CNIDir, fmt.Sprintf("%s-%s-switchdev", containerID, ifName))
+}
+
+func physSwitchID(ifaceName string) (string, error) {
+	id, err := ioutil.ReadFile(filepath.Join("/sys/class/net", ifaceName, "phys_switch_id"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(id)), nil
+}
+
+func physPortName(ifaceName string) (string, error) {
+	name, err := ioutil.ReadFile(filepath.Join("/sys/class/net", ifaceName, "phys_port_name"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(name)), nil
+}
+
+// findVFRepresentor locates the host-side representor netdev for the VF
+// identified by vfid on PF pfName, matching on phys_switch_id (same ASIC as
+// the PF) and phys_port_name (the kernel's "pf<N>vf<M>" representor naming).
+func findVFRepresentor(pfName string, vfid int) (string, error) {
+	pfSwitchID, err := physSwitchID(pfName)
+	if err != nil || pfSwitchID == "" {
+		return "", fmt.Errorf("failed to resolve phys_switch_id for PF %q: %v", pfName, err)
+	}
+
+	entries, err := ioutil.ReadDir("/sys/class/net")
+	if err != nil {
+		return "", fmt.Errorf("failed to list /sys/class/net: %v", err)
+	}
+
+	wantVfid := strconv.Itoa(vfid)
+	for _, e := range entries {
+		name := e.Name()
+		switchID, err := physSwitchID(name)
+		if err != nil || switchID != pfSwitchID {
+			continue
+		}
+
+		portName, err := physPortName(name)
+		if err != nil {
+			continue
+		}
+		m := vfRepresentorPortName.FindStringSubmatch(portName)
+		if m != nil && m[1] == wantVfid {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("no representor found for VF %d of PF %q", vfid, pfName)
+}
+
+// setupVFRepresentor resolves the representor netdev for the VF described
+// by n.DeviceInfo and, if n.OvsBridge is set, attaches it to that bridge
+// tagged with the container ID so OVS hardware-offload can match it back to
+// the pod. It returns the representor name so callers can surface it.
+func setupVFRepresentor(n *config.NetConf, ifName, containerID string) (string, error) {
+	rep, err := findVFRepresentor(n.DeviceInfo.Pfname, n.DeviceInfo.Vfid)
+	if err != nil {
+		return "", err
+	}
+
+	if n.OvsBridge != "" {
+		args := []string{"add-port", n.OvsBridge, rep,
+			"--", "set", "Interface", rep, "external_ids:iface-id=" + containerID}
+		if out, err := exec.Command("ovs-vsctl", args...).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("ovs-vsctl add-port %q %q failed: %v: %s", n.OvsBridge, rep, err, out)
+		}
+	}
+
+	if err := os.MkdirAll(n.CNIDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create the sriov data directory(%q): %v", n.CNIDir, err)
+	}
+
+	state := switchdevState{Representor: rep, OvsBridge: n.OvsBridge}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal switchdev state: %v", err)
+	}
+	if err := ioutil.WriteFile(switchdevStatePath(n, containerID, ifName), data, 0600); err != nil {
+		return "", fmt.Errorf("failed to persist switchdev state: %v", err)
+	}
+
+	return rep, nil
+}
+
+// releaseVFRepresentor undoes the ovsBridge attachment made by
+// setupVFRepresentor, if any.
+func releaseVFRepresentor(n *config.NetConf, ifName, containerID string) error {
+	path := switchdevStatePath(n, containerID, ifName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read switchdev state %q: %v", path, err)
+	}
+
+	var state switchdevState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse switchdev state %q: %v", path, err)
+	}
+
+	if state.OvsBridge != "" {
+		if out, err := exec.Command("ovs-vsctl", "del-port", state.OvsBridge, state.Representor).CombinedOutput(); err != nil {
+			return fmt.Errorf("ovs-vsctl del-port %q %q failed: %v: %s", state.OvsBridge, state.Representor, err, out)
+		}
+	}
+
+	return os.Remove(path)
+}